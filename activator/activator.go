@@ -0,0 +1,87 @@
+// Package activator drives component activation/deactivation against
+// workers, routing every call through a worker/xfer.TransferManager so
+// concurrent requests for the same component+worker are deduplicated and
+// retried with backoff instead of being fired off one at a time.
+package activator
+
+import (
+	"context"
+	"fmt"
+
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+	"v9_deployment_manager/worker/xfer"
+)
+
+type Activator struct {
+	transfers *xfer.TransferManager
+	logger    *log.Logger
+}
+
+// NewActivator builds an Activator. A nil logger falls back to log.Default.
+func NewActivator(logger *log.Logger) *Activator {
+	if logger == nil {
+		logger = log.Default
+	}
+	return &Activator{transfers: xfer.NewTransferManager(xfer.DefaultConfig()), logger: logger}
+}
+
+// transferKey includes kind so an activation never attaches to an in-flight
+// deactivation of the same component on the same worker, or vice versa.
+func transferKey(kind xfer.Kind, compID worker.ComponentID, w *worker.V9Worker) xfer.Key {
+	return xfer.Key{
+		ComponentID: fmt.Sprintf("%d:%s/%s@%s", kind, compID.User, compID.Repo, compID.Hash),
+		WorkerURL:   w.URL,
+	}
+}
+
+func tarPathFor(compID worker.ComponentID) string {
+	return fmt.Sprintf("https://github.com/%s/%s/archive/%s.tar.gz", compID.User, compID.Repo, compID.Hash)
+}
+
+// Activate submits an activation of compID on w, deduping against any
+// in-flight activation of the same component on the same worker. It blocks
+// until the transfer (including retries) completes and returns the hash the
+// worker resolved and activated, along with how many attempts the
+// transfer's retry loop (in worker/xfer) made.
+func (a *Activator) Activate(ctx context.Context, compID worker.ComponentID, w *worker.V9Worker) (string, int, error) {
+	logger := a.logger.With(log.F("user", compID.User), log.F("repo", compID.Repo),
+		log.F("hash", compID.Hash), log.F("worker_url", w.URL))
+
+	key := transferKey(xfer.KindActivate, compID, w)
+	logger.Debug("Submitting activate transfer")
+	transfer := a.transfers.Submit(ctx, key, xfer.KindActivate, func(ctx context.Context) (interface{}, error) {
+		return w.Activate(ctx, compID, tarPathFor(compID))
+	})
+
+	<-transfer.Done()
+	if err := transfer.Err(); err != nil {
+		logger.Error("Activate transfer failed", log.F("error", err))
+		return "", transfer.Attempts(), err
+	}
+
+	resolvedHash, _ := transfer.Result().(string)
+	return resolvedHash, transfer.Attempts(), nil
+}
+
+// Deactivate submits a deactivation of compID on w, deduping against any
+// in-flight deactivation of the same component on the same worker. It
+// blocks until the transfer (including retries) completes and returns how
+// many attempts it took.
+func (a *Activator) Deactivate(ctx context.Context, compID worker.ComponentID, w *worker.V9Worker) (int, error) {
+	logger := a.logger.With(log.F("user", compID.User), log.F("repo", compID.Repo),
+		log.F("hash", compID.Hash), log.F("worker_url", w.URL))
+
+	key := transferKey(xfer.KindDeactivate, compID, w)
+	logger.Debug("Submitting deactivate transfer")
+	transfer := a.transfers.Submit(ctx, key, xfer.KindDeactivate, func(ctx context.Context) (interface{}, error) {
+		return nil, w.Deactivate(ctx, compID)
+	})
+
+	<-transfer.Done()
+	if err := transfer.Err(); err != nil {
+		logger.Error("Deactivate transfer failed", log.F("error", err))
+		return transfer.Attempts(), err
+	}
+	return transfer.Attempts(), nil
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"database/sql"
+
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// Driver wraps the backing store that tracks which components should be
+// active and which hashes they should be running.
+type Driver struct {
+	db *sql.DB
+
+	// Logger receives this driver's (and any Watcher it starts) log lines.
+	// A nil Logger falls back to log.Default.
+	Logger *log.Logger
+}
+
+func NewDriver(db *sql.DB) *Driver {
+	return &Driver{db: db}
+}
+
+func (d *Driver) logger() *log.Logger {
+	if d.Logger == nil {
+		return log.Default
+	}
+	return d.Logger
+}
+
+// ResponseMetadata carries the wait-index a caller can hand back to a
+// follow-up query to block (or poll) until the underlying data has changed,
+// Consul-KV-view style.
+type ResponseMetadata struct {
+	LastIndex uint64
+}
+
+// FindActiveComponents returns every component path that is supposed to be
+// running somewhere in the cluster, along with the index of the active-set
+// row that changed most recently. A Watcher uses LastIndex to know when it
+// needs to push a fresh snapshot.
+func (d *Driver) FindActiveComponents() ([]worker.ComponentPath, ResponseMetadata, error) {
+	var lastIndex uint64
+	if err := d.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM active_components").Scan(&lastIndex); err != nil {
+		return nil, ResponseMetadata{}, err
+	}
+
+	rows, err := d.db.Query("SELECT user, repo FROM active_components")
+	if err != nil {
+		return nil, ResponseMetadata{}, err
+	}
+	defer rows.Close()
+
+	active := make([]worker.ComponentPath, 0)
+	for rows.Next() {
+		var path worker.ComponentPath
+		if err := rows.Scan(&path.User, &path.Repo); err != nil {
+			return nil, ResponseMetadata{}, err
+		}
+		active = append(active, path)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, ResponseMetadata{}, err
+	}
+
+	return active, ResponseMetadata{LastIndex: lastIndex}, nil
+}
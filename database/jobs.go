@@ -0,0 +1,111 @@
+package database
+
+import (
+	"time"
+
+	"v9_deployment_manager/worker"
+)
+
+// JobKind identifies what an async job does.
+type JobKind string
+
+const (
+	ActivateJob   JobKind = "activate"
+	DeactivateJob JobKind = "deactivate"
+	// ReplicateJob is reserved for cross-worker replication; nothing
+	// enqueues it yet.
+	ReplicateJob JobKind = "replicate"
+)
+
+// JobState is the lifecycle of a job from submission to completion.
+type JobState string
+
+const (
+	JobPending   JobState = "pending"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// JobRecord is the persisted record of an activate/deactivate/replicate
+// call, so operators can see what happened (and why it failed) after the
+// fact instead of the result only ever reaching a log line.
+type JobRecord struct {
+	ID          string
+	Kind        JobKind
+	ComponentID worker.ComponentID
+	WorkerURL   string
+
+	State    JobState
+	Error    string
+	Result   string
+	Attempts int
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (d *Driver) CreateJob(job JobRecord) error {
+	_, err := d.db.Exec(
+		`INSERT INTO jobs (id, kind, user, repo, hash, worker_url, state, error, result, attempts, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.Kind, job.ComponentID.User, job.ComponentID.Repo, job.ComponentID.Hash,
+		job.WorkerURL, job.State, job.Error, job.Result, job.Attempts, job.CreatedAt, job.UpdatedAt,
+	)
+	return err
+}
+
+func (d *Driver) UpdateJob(job JobRecord) error {
+	_, err := d.db.Exec(
+		`UPDATE jobs SET state = ?, error = ?, result = ?, attempts = ?, updated_at = ? WHERE id = ?`,
+		job.State, job.Error, job.Result, job.Attempts, job.UpdatedAt, job.ID,
+	)
+	return err
+}
+
+func (d *Driver) GetJob(id string) (JobRecord, error) {
+	row := d.db.QueryRow(
+		`SELECT id, kind, user, repo, hash, worker_url, state, error, result, attempts, created_at, updated_at
+		 FROM jobs WHERE id = ?`, id,
+	)
+	return scanJob(row)
+}
+
+func (d *Driver) ListJobs() ([]JobRecord, error) {
+	rows, err := d.db.Query(
+		`SELECT id, kind, user, repo, hash, worker_url, state, error, result, attempts, created_at, updated_at
+		 FROM jobs ORDER BY created_at DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]JobRecord, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// rowScanner covers the subset of *sql.Row and *sql.Rows that Scan needs.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (JobRecord, error) {
+	var job JobRecord
+	err := row.Scan(
+		&job.ID, &job.Kind,
+		&job.ComponentID.User, &job.ComponentID.Repo, &job.ComponentID.Hash,
+		&job.WorkerURL, &job.State, &job.Error, &job.Result, &job.Attempts,
+		&job.CreatedAt, &job.UpdatedAt,
+	)
+	return job, err
+}
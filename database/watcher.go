@@ -0,0 +1,106 @@
+package database
+
+import (
+	"time"
+
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+// defaultRetry is how long a Watcher backs off after a failed query before
+// trying again.
+const defaultRetry = 5 * time.Second
+
+// pollInterval is how long a Watcher waits between queries when the active
+// set hasn't changed. Real long-poll backends (Consul, etcd) would instead
+// block server-side on the index; here we approximate that with a poll loop.
+const pollInterval = time.Second
+
+// Snapshot is a point-in-time view of the active component set, tagged with
+// the index it was read at.
+type Snapshot struct {
+	Components []worker.ComponentPath
+	Index      uint64
+}
+
+// Watcher long-polls FindActiveComponents for changes to the active set,
+// pushing a new Snapshot only when the index moves. It lets callers react
+// to "active set changed" separately from "hash updated", which is driven
+// on its own path (see deployment.ActionManager's pathHashUpdater).
+type Watcher struct {
+	driver *Driver
+
+	snapshots chan Snapshot
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// NewWatcher starts watching the active component set in the background.
+func (d *Driver) NewWatcher() *Watcher {
+	w := &Watcher{
+		driver:    d,
+		snapshots: make(chan Snapshot, 1),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+// Snapshots returns the channel new active-set snapshots are pushed on.
+func (w *Watcher) Snapshots() <-chan Snapshot {
+	return w.snapshots
+}
+
+// Stop closes the watcher's goroutine and blocks until it has exited.
+func (w *Watcher) Stop() {
+	close(w.stop)
+	<-w.stopped
+}
+
+func (w *Watcher) run() {
+	defer close(w.stopped)
+
+	var lastIndex uint64
+	haveIndex := false
+
+	for {
+		components, meta, err := w.driver.FindActiveComponents()
+		if err != nil {
+			w.driver.logger().Error("Watcher failed to query active components", log.F("error", err))
+			if !w.sleep(defaultRetry) {
+				return
+			}
+			continue
+		}
+
+		if haveIndex && meta.LastIndex == lastIndex {
+			if !w.sleep(pollInterval) {
+				return
+			}
+			continue
+		}
+
+		lastIndex = meta.LastIndex
+		haveIndex = true
+
+		select {
+		case w.snapshots <- Snapshot{Components: components, Index: meta.LastIndex}:
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// sleep waits for d, returning false if the watcher was stopped in the
+// meantime.
+func (w *Watcher) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-w.stop:
+		return false
+	}
+}
@@ -1,10 +1,14 @@
 package deployment
 
 import (
-	"math/rand"
+	"context"
+	"errors"
+	"fmt"
 	"sync"
-	"v9_deployment_manager/activator"
+	"sync/atomic"
+
 	"v9_deployment_manager/database"
+	"v9_deployment_manager/jobservice"
 	"v9_deployment_manager/log"
 	"v9_deployment_manager/worker"
 )
@@ -13,34 +17,133 @@ const headHashSentinel = "HEAD"
 const updaterChanSize = 1024
 
 type ActionManager struct {
-	driver *database.Driver
+	driver  *database.Driver
+	watcher *database.Watcher
 
-	activator *activator.Activator
+	jobs      *jobservice.Service
 	workers   []*worker.V9Worker
+	scheduler worker.Scheduler
+
+	// Logger receives this manager's log lines. A nil Logger falls back to
+	// log.Default. Each dirty-state pass attaches its own run_id on top.
+	Logger *log.Logger
+
+	runCounter uint64
 
 	pathHashMux     sync.Mutex
 	pathHashes      map[worker.ComponentPath]string
 	pathHashUpdater chan worker.ComponentID
 
-	dirtyStateNotifier chan struct{}
+	activeMux sync.Mutex
+	active    []worker.ComponentPath
+
+	// dirtyNotifier is how both a target-hash change and an active-set
+	// change ask for a re-run of HandleDirtyState. Both sources funnel
+	// through this single channel and its single consumer goroutine below,
+	// so the two triggers can never run HandleDirtyState concurrently with
+	// each other and race their generation-cancel against a pass that isn't
+	// actually stale.
+	dirtyNotifier chan struct{}
+
+	genMux    sync.Mutex
+	genCancel context.CancelFunc
 }
 
-func NewActionManager(activator *activator.Activator, dr *database.Driver, workers []*worker.V9Worker) *ActionManager {
+func (mgr *ActionManager) logger() *log.Logger {
+	if mgr.Logger == nil {
+		return log.Default
+	}
+	return mgr.Logger
+}
+
+// pathHash and setPathHash take pathHashMux around each individual access.
+// HandleDirtyState fans work for a single pass out across many goroutines
+// (via runConcurrent), so the map can't just be locked for the pass as a
+// whole -- these are the only safe way to read or write it.
+func (mgr *ActionManager) pathHash(path worker.ComponentPath) (string, bool) {
+	mgr.pathHashMux.Lock()
+	defer mgr.pathHashMux.Unlock()
+	hash, ok := mgr.pathHashes[path]
+	return hash, ok
+}
+
+func (mgr *ActionManager) setPathHash(path worker.ComponentPath, hash string) {
+	mgr.pathHashMux.Lock()
+	defer mgr.pathHashMux.Unlock()
+	mgr.pathHashes[path] = hash
+}
+
+// dirtyStatePass tracks the run_id and outcome counts for a single
+// HandleDirtyState invocation, so the pass can end with one structured
+// summary line instead of scattering its totals across the log.
+type dirtyStatePass struct {
+	logger *log.Logger
+
+	mu          sync.Mutex
+	activated   int
+	deactivated int
+	retried     int
+	failed      int
+}
+
+func (p *dirtyStatePass) record(kind database.JobKind, job database.JobRecord) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch kind {
+	case database.ActivateJob:
+		p.activated++
+	case database.DeactivateJob:
+		p.deactivated++
+	}
+	if job.Attempts > 1 {
+		p.retried += job.Attempts - 1
+	}
+	if job.State == database.JobFailed {
+		p.failed++
+	}
+}
+
+type passContextKey struct{}
+
+func withPass(ctx context.Context, pass *dirtyStatePass) context.Context {
+	return context.WithValue(ctx, passContextKey{}, pass)
+}
+
+func passFromContext(ctx context.Context) *dirtyStatePass {
+	pass, _ := ctx.Value(passContextKey{}).(*dirtyStatePass)
+	return pass
+}
+
+// loggerFromContext returns the current pass's logger (which already
+// carries run_id), falling back to the manager's own logger for calls made
+// outside a pass, such as the background goroutines started below.
+func (mgr *ActionManager) loggerFromContext(ctx context.Context) *log.Logger {
+	if pass := passFromContext(ctx); pass != nil {
+		return pass.logger
+	}
+	return mgr.logger()
+}
+
+func NewActionManager(jobs *jobservice.Service, dr *database.Driver, workers []*worker.V9Worker, scheduler worker.Scheduler, logger *log.Logger) *ActionManager {
 	pathHashes := make(map[worker.ComponentPath]string)
 
 	pathHashUpdater := make(chan worker.ComponentID, updaterChanSize)
-	dirtyStateNotifier := make(chan struct{}, 1)
+	dirtyNotifier := make(chan struct{}, 1)
 
 	mgr := &ActionManager{
-		driver: dr,
+		driver:  dr,
+		watcher: dr.NewWatcher(),
 
-		activator: activator,
+		jobs:      jobs,
 		workers:   workers,
+		scheduler: scheduler,
+		Logger:    logger,
 
 		pathHashes:      pathHashes,
 		pathHashUpdater: pathHashUpdater,
 
-		dirtyStateNotifier: dirtyStateNotifier,
+		dirtyNotifier: dirtyNotifier,
 	}
 
 	go func() {
@@ -51,32 +154,50 @@ func NewActionManager(activator *activator.Activator, dr *database.Driver, worke
 				Repo: updatedID.Repo,
 			}
 
-			mgr.pathHashMux.Lock()
-			mgr.pathHashes[path] = updatedID.Hash
-			mgr.pathHashMux.Unlock()
+			mgr.setPathHash(path, updatedID.Hash)
 
 			mgr.NotifyComponentStateChanged()
 		}
 	}()
 
+	// The single consumer of dirtyNotifier: this is what serializes
+	// HandleDirtyState against itself, regardless of which source below
+	// triggered it.
 	go func() {
 		for {
-			// Whenever we get a dirty state notification
-			<-mgr.dirtyStateNotifier
+			<-mgr.dirtyNotifier
 			err := mgr.HandleDirtyState()
 			if err != nil {
-				log.Error.Println("Could not manage components:", err)
+				mgr.logger().Error("Could not manage components", log.F("error", err))
 			}
 		}
 	}()
 
+	go func() {
+		for snapshot := range mgr.watcher.Snapshots() {
+			mgr.logger().Info("Active component set changed", log.F("index", snapshot.Index))
+
+			mgr.activeMux.Lock()
+			mgr.active = snapshot.Components
+			mgr.activeMux.Unlock()
+
+			mgr.NotifyComponentStateChanged()
+		}
+	}()
+
 	return mgr
 }
 
+// Stop shuts the manager's background watcher down cleanly, for tests and
+// process shutdown.
+func (mgr *ActionManager) Stop() {
+	mgr.watcher.Stop()
+}
+
 func (mgr *ActionManager) NotifyComponentStateChanged() {
-	// Put something in the `dirtyStateNotifier` -- unless someone else already notified that the state was dirty
+	// Put something on dirtyNotifier -- unless someone else already notified that the state was dirty
 	select {
-	case mgr.dirtyStateNotifier <- struct{}{}:
+	case mgr.dirtyNotifier <- struct{}{}:
 	default:
 	}
 }
@@ -85,71 +206,197 @@ func (mgr *ActionManager) UpdateComponentHash(compID worker.ComponentID) {
 	mgr.pathHashUpdater <- compID
 }
 
-func (mgr *ActionManager) HandleDirtyState() error {
-	// TODO: Parallelize this step (it basically single threads the deployment manager at the moment)
+// newGeneration cancels the context used by the previous dirty-state pass
+// (dropping any of its transfers that haven't started yet) and returns a
+// fresh one for the pass that is about to begin.
+func (mgr *ActionManager) newGeneration() context.Context {
+	mgr.genMux.Lock()
+	defer mgr.genMux.Unlock()
 
-	// TODO: Smarter error handling
+	if mgr.genCancel != nil {
+		mgr.genCancel()
+	}
 
-	// Lock the component hashes in place
-	mgr.pathHashMux.Lock()
-	defer mgr.pathHashMux.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	mgr.genCancel = cancel
+	return ctx
+}
 
-	log.Info.Println("Beginning dirty state handling")
+// runJob enqueues kind against compID on w and blocks until the job reaches
+// a terminal state, reporting the outcome to the scheduler and returning
+// the job's result (the activated hash, for ActivateJob) on success. This
+// replaces calling the activator directly, so every call is recorded in the
+// job store. Each call enqueues a new job: Enqueue does not look for an
+// existing in-flight job for the same (compID, worker), and duplicate calls
+// against the worker are only collapsed by the Activator's transfer dedup.
+func (mgr *ActionManager) runJob(ctx context.Context, kind database.JobKind, compID worker.ComponentID, w *worker.V9Worker) (string, error) {
+	job, err := mgr.jobs.Enqueue(ctx, kind, compID, w.URL)
+	if err != nil {
+		return "", err
+	}
 
-	active, err := mgr.driver.FindActiveComponents()
+	completed, err := mgr.jobs.Await(ctx, job.ID)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	var jobErr error
+	if completed.State != database.JobSucceeded {
+		jobErr = errors.New(completed.Error)
+	}
+	mgr.scheduler.ReportActivateResult(w, jobErr)
+
+	if pass := passFromContext(ctx); pass != nil {
+		pass.record(kind, completed)
+	}
+
+	return completed.Result, jobErr
+}
+
+// runConcurrent runs every op in its own goroutine and waits for all of them
+// to finish, returning the first error encountered (if any). Each op is
+// expected to block on its own transfer completing, so this is what lets
+// HandleDirtyState await a whole batch of activations/deactivations instead
+// of looping over them serially.
+func runConcurrent(ops []func() error) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	errs := make(chan error, len(ops))
+	for _, op := range ops {
+		op := op
+		go func() { errs <- op() }()
+	}
+
+	var firstErr error
+	for range ops {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (mgr *ActionManager) HandleDirtyState() error {
+	// TODO: Smarter error handling
+
+	ctx := mgr.newGeneration()
+
+	runID := fmt.Sprintf("run-%d", atomic.AddUint64(&mgr.runCounter, 1))
+	pass := &dirtyStatePass{logger: mgr.logger().With(log.F("run_id", runID))}
+	ctx = withPass(ctx, pass)
+	logger := pass.logger
+
+	logger.Info("Beginning dirty state handling")
+
+	mgr.activeMux.Lock()
+	active := mgr.active
+	mgr.activeMux.Unlock()
+	if active == nil {
+		// The watcher hasn't delivered its first snapshot yet.
+		logger.Info("No active component snapshot yet, skipping this pass")
+		return nil
 	}
 
 	// deactivate things that should not be running anywhere
-	log.Info.Println("Deactivating non-active components")
+	logger.Info("Deactivating non-active components")
+	if err := mgr.deactivateNonactive(ctx, active); err != nil {
+		return err
+	}
+
+	// start things that should be running somewhere but are not
+	logger.Info("Starting active but not running components")
+	if err := mgr.activateAllMissing(ctx, active); err != nil {
+		return err
+	}
+
+	// ensure that, for each component, there is a worker running the latest version
+	logger.Info("Ensuring that every component has the latest version running somewhere")
+	if err := mgr.ensureAllWorkersRunning(ctx, active); err != nil {
+		return err
+	}
+
+	// deactivate workers running old hashes of components
+	logger.Info("Deactivating old hashes wherever they are")
+	if err := mgr.deactivateAllOldHashes(ctx, active); err != nil {
+		return err
+	}
+
+	logger.Info("Finished dirty state handling",
+		log.F("activated", pass.activated),
+		log.F("deactivated", pass.deactivated),
+		log.F("retried", pass.retried),
+		log.F("failed", pass.failed),
+	)
+	return nil
+}
+
+// deactivateNonactive submits, across every worker, a deactivation for each
+// component that worker is running but that is no longer in active, and
+// waits for all of them to complete.
+func (mgr *ActionManager) deactivateNonactive(ctx context.Context, active []worker.ComponentPath) error {
+	ops := make([]func() error, 0)
+
 	for _, w := range mgr.workers {
-		err = mgr.deactivateNonactive(w, active)
+		w := w
+
+		status, err := w.Status()
 		if err != nil {
 			return err
 		}
+
+		for _, incorrectlyRunning := range status.FindNonactive(active) {
+			incorrectlyRunning := incorrectlyRunning
+			mgr.loggerFromContext(ctx).Info("Deactivating incorrectly running component",
+				log.F("user", incorrectlyRunning.User), log.F("repo", incorrectlyRunning.Repo),
+				log.F("hash", incorrectlyRunning.Hash), log.F("worker_url", w.URL))
+
+			ops = append(ops, func() error {
+				_, err := mgr.runJob(ctx, database.DeactivateJob, incorrectlyRunning, w)
+				return err
+			})
+		}
 	}
 
-	// start things that should be running somewhere but are not
-	log.Info.Println("Starting active but not running components")
+	return runConcurrent(ops)
+}
+
+// activateAllMissing submits an activation for every active component that
+// is not currently running on any worker.
+func (mgr *ActionManager) activateAllMissing(ctx context.Context, active []worker.ComponentPath) error {
+	ops := make([]func() error, 0, len(active))
+
 	for _, activeComp := range active {
+		activeComp := activeComp
+
 		var hashToDeploy = headHashSentinel
-		if mapHash, ok := mgr.pathHashes[activeComp]; ok {
+		if mapHash, ok := mgr.pathHash(activeComp); ok {
 			hashToDeploy = mapHash
 		}
 
-		err = mgr.activateMissing(worker.ComponentID{
+		toCheck := worker.ComponentID{
 			User: activeComp.User,
 			Repo: activeComp.Repo,
 			Hash: hashToDeploy,
-		})
-		if err != nil {
-			return err
 		}
-	}
 
-	// ensure that, for each component, there is a worker running the latest version
-	log.Info.Println("Ensuring that every component has the latest version running somewhere")
-	for _, activeComp := range active {
-		// We only need to make sure things are up to date when we know what's supposed to be running
-		if correctHash, ok := mgr.pathHashes[activeComp]; ok {
-			correctCompID := worker.ComponentID{
-				User: activeComp.User,
-				Repo: activeComp.Repo,
-				Hash: correctHash,
-			}
-			err = mgr.ensureSomeWorkerIsRunning(correctCompID)
-			if err != nil {
-				return err
-			}
-		}
+		ops = append(ops, func() error {
+			return mgr.activateMissing(ctx, toCheck)
+		})
 	}
 
-	// deactivate workers running old hashes of components
-	log.Info.Println("Deactivating old hashes wherever they are")
+	return runConcurrent(ops)
+}
+
+// ensureAllWorkersRunning submits, for every active component we know the
+// correct hash for, the work needed to ensure some worker is running it.
+func (mgr *ActionManager) ensureAllWorkersRunning(ctx context.Context, active []worker.ComponentPath) error {
+	ops := make([]func() error, 0, len(active))
+
 	for _, activeComp := range active {
-		correctHash, ok := mgr.pathHashes[activeComp]
-		// If we couldn't grab the correct hash, whatever -- assume we're chugging along fine
+		// We only need to make sure things are up to date when we know what's supposed to be running
+		correctHash, ok := mgr.pathHash(activeComp)
 		if !ok {
 			continue
 		}
@@ -159,38 +406,45 @@ func (mgr *ActionManager) HandleDirtyState() error {
 			Repo: activeComp.Repo,
 			Hash: correctHash,
 		}
-		for _, w := range mgr.workers {
-			err = mgr.deactivateIfHashDiffers(w, correctCompID)
-			if err != nil {
-				return err
-			}
-		}
+
+		ops = append(ops, func() error {
+			return mgr.ensureSomeWorkerIsRunning(ctx, correctCompID)
+		})
 	}
 
-	log.Info.Println("Finished dirty state handling")
-	return nil
+	return runConcurrent(ops)
 }
 
-func (mgr *ActionManager) deactivateNonactive(w *worker.V9Worker, active []worker.ComponentPath) error {
-	status, err := w.Status()
-	if err != nil {
-		return err
-	}
+// deactivateAllOldHashes submits, across every worker and active component,
+// a deactivation for any hash of that component other than the correct one.
+func (mgr *ActionManager) deactivateAllOldHashes(ctx context.Context, active []worker.ComponentPath) error {
+	ops := make([]func() error, 0)
 
-	nonActive := status.FindNonactive(active)
-	for _, incorrectlyRunning := range nonActive {
-		log.Info.Println("Deactivating incorrectly running", incorrectlyRunning, "on worker", w.URL)
+	for _, activeComp := range active {
+		correctHash, ok := mgr.pathHash(activeComp)
+		// If we couldn't grab the correct hash, whatever -- assume we're chugging along fine
+		if !ok {
+			continue
+		}
 
-		err := mgr.activator.Deactivate(incorrectlyRunning, w)
-		if err != nil {
-			return err
+		correctCompID := worker.ComponentID{
+			User: activeComp.User,
+			Repo: activeComp.Repo,
+			Hash: correctHash,
+		}
+
+		for _, w := range mgr.workers {
+			w := w
+			ops = append(ops, func() error {
+				return mgr.deactivateIfHashDiffers(ctx, w, correctCompID)
+			})
 		}
 	}
 
-	return nil
+	return runConcurrent(ops)
 }
 
-func (mgr *ActionManager) activateMissing(toCheck worker.ComponentID) error {
+func (mgr *ActionManager) activateMissing(ctx context.Context, toCheck worker.ComponentID) error {
 	path := worker.ComponentPath{
 		User: toCheck.User,
 		Repo: toCheck.Repo,
@@ -208,33 +462,34 @@ func (mgr *ActionManager) activateMissing(toCheck worker.ComponentID) error {
 		}
 	}
 
-	// Otherwise pick a worker randomly and deploy there
-	randomWorker := mgr.workers[rand.Intn(len(mgr.workers))]
-	log.Info.Println("Activating missing", toCheck, "on worker", randomWorker)
-	activatedHash, err := mgr.activator.Activate(toCheck, randomWorker)
+	// Otherwise ask the scheduler for the least-loaded healthy worker and deploy there
+	chosenWorker, err := mgr.scheduler.Pick(mgr.workers, path, false)
+	if err != nil {
+		return err
+	}
+
+	mgr.loggerFromContext(ctx).Info("Activating missing component",
+		log.F("user", toCheck.User), log.F("repo", toCheck.Repo), log.F("hash", toCheck.Hash),
+		log.F("worker_url", chosenWorker.URL))
+	activatedHash, err := mgr.runJob(ctx, database.ActivateJob, toCheck, chosenWorker)
 	if err != nil {
 		return err
 	}
 
 	// Update the relevant hash (if we're using HEAD) so the map will match in the update step
 	if toCheck.Hash == headHashSentinel {
-		mgr.pathHashes[worker.ComponentPath{
-			User: toCheck.User,
-			Repo: toCheck.Repo,
-		}] = activatedHash
+		mgr.setPathHash(path, activatedHash)
 	}
 
 	return nil
 }
 
-func (mgr *ActionManager) ensureSomeWorkerIsRunning(compID worker.ComponentID) error {
+func (mgr *ActionManager) ensureSomeWorkerIsRunning(ctx context.Context, compID worker.ComponentID) error {
 	compPath := worker.ComponentPath{
 		User: compID.User,
 		Repo: compID.Repo,
 	}
 
-	notRunningAnyVersion := make([]*worker.V9Worker, 0)
-
 	for _, w := range mgr.workers {
 		status, err := w.Status()
 		if err != nil {
@@ -247,54 +502,53 @@ func (mgr *ActionManager) ensureSomeWorkerIsRunning(compID worker.ComponentID) e
 				return nil
 			}
 		}
-
-		if !status.ContainsPath(compPath) {
-			notRunningAnyVersion = append(notRunningAnyVersion, w)
-		}
 	}
 
-	// If we get here we need to deploy to some worker
-	var workerToDeployTo *worker.V9Worker
-	if len(notRunningAnyVersion) > 0 {
-		workerToDeployTo = notRunningAnyVersion[rand.Intn(len(notRunningAnyVersion))]
-	} else {
-		// If everyone is running it, then we need to create a place to deploy to
-		workerToDeployTo = mgr.workers[rand.Intn(len(mgr.workers))]
-		err := mgr.activator.Deactivate(compID, workerToDeployTo)
-		if err != nil {
-			return err
-		}
+	// Nobody is running this exact ID yet -- ask the scheduler for a worker
+	// that isn't already running some version of this component's path. If
+	// every worker is already running it, that's a genuine capacity problem;
+	// we no longer deactivate-then-reactivate on the same worker to "make room".
+	workerToDeployTo, err := mgr.scheduler.Pick(mgr.workers, compPath, true)
+	if err != nil {
+		return err
 	}
 
-	log.Info.Println("Doing to deploy to ensure", compID, "is on some worker", workerToDeployTo)
-	deployedHash, err := mgr.activator.Activate(compID, workerToDeployTo)
+	mgr.loggerFromContext(ctx).Info("Deploying to ensure component is running somewhere",
+		log.F("user", compID.User), log.F("repo", compID.Repo), log.F("hash", compID.Hash),
+		log.F("worker_url", workerToDeployTo.URL))
+	deployedHash, err := mgr.runJob(ctx, database.ActivateJob, compID, workerToDeployTo)
 	if err != nil {
 		return err
 	}
 
 	// Update the hash we're storing if we had HEAD
 	if compID.Hash == headHashSentinel {
-		mgr.pathHashes[compPath] = deployedHash
+		mgr.setPathHash(compPath, deployedHash)
 	}
 
 	return nil
 }
 
-func (mgr *ActionManager) deactivateIfHashDiffers(w *worker.V9Worker, compID worker.ComponentID) error {
+func (mgr *ActionManager) deactivateIfHashDiffers(ctx context.Context, w *worker.V9Worker, compID worker.ComponentID) error {
 	status, err := w.Status()
 	if err != nil {
 		return err
 	}
 
+	ops := make([]func() error, 0)
 	for _, runningComp := range status.ActiveComponents {
 		if runningComp.ID.User == compID.User && runningComp.ID.Repo == compID.Repo && runningComp.ID.Hash != compID.Hash {
-			log.Info.Println("Doing to deactivate to ensure", w.URL, "does not keep running", compID)
-			err = mgr.activator.Deactivate(runningComp.ID, w)
-			if err != nil {
+			runningComp := runningComp
+			mgr.loggerFromContext(ctx).Info("Deactivating stale hash",
+				log.F("user", compID.User), log.F("repo", compID.Repo),
+				log.F("running_hash", runningComp.ID.Hash), log.F("correct_hash", compID.Hash),
+				log.F("worker_url", w.URL))
+			ops = append(ops, func() error {
+				_, err := mgr.runJob(ctx, database.DeactivateJob, runningComp.ID, w)
 				return err
-			}
+			})
 		}
 	}
 
-	return nil
+	return runConcurrent(ops)
 }
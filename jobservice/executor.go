@@ -0,0 +1,45 @@
+package jobservice
+
+import (
+	"context"
+	"fmt"
+
+	"v9_deployment_manager/activator"
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/worker"
+)
+
+// ActivatorExecutor executes jobs against the deployment's configured
+// workers via an Activator, so activate/deactivate calls still go through
+// the same xfer-backed dedup/retry/concurrency limits they always have --
+// jobservice only adds persistence and visibility on top, and does not retry
+// on its own: the attempts Execute reports are the Activator's.
+type ActivatorExecutor struct {
+	activator *activator.Activator
+	workers   map[string]*worker.V9Worker
+}
+
+func NewActivatorExecutor(act *activator.Activator, workers []*worker.V9Worker) *ActivatorExecutor {
+	byURL := make(map[string]*worker.V9Worker, len(workers))
+	for _, w := range workers {
+		byURL[w.URL] = w
+	}
+	return &ActivatorExecutor{activator: act, workers: byURL}
+}
+
+func (e *ActivatorExecutor) Execute(ctx context.Context, job Job) (string, int, error) {
+	w, ok := e.workers[job.WorkerURL]
+	if !ok {
+		return "", 0, fmt.Errorf("jobservice: unknown worker %q", job.WorkerURL)
+	}
+
+	switch job.Kind {
+	case database.ActivateJob:
+		return e.activator.Activate(ctx, job.ComponentID, w)
+	case database.DeactivateJob:
+		attempts, err := e.activator.Deactivate(ctx, job.ComponentID, w)
+		return "", attempts, err
+	default:
+		return "", 0, fmt.Errorf("jobservice: unsupported job kind %q", job.Kind)
+	}
+}
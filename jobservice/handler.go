@@ -0,0 +1,65 @@
+package jobservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// RegisterHandlers mounts list/inspect/cancel job endpoints under prefix
+// (e.g. mux.Handle with prefix "/jobs/") on the deployment manager's mux:
+//
+//	GET  /jobs/          list every known job
+//	GET  /jobs/<id>      inspect a single job
+//	POST /jobs/<id>/cancel  cancel a pending or running job
+func (s *Service) RegisterHandlers(mux *http.ServeMux, prefix string) {
+	mux.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+		s.serveHTTP(w, r, prefix)
+	})
+}
+
+func (s *Service) serveHTTP(w http.ResponseWriter, r *http.Request, prefix string) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		s.handleList(w)
+	case strings.HasSuffix(id, "/cancel") && r.Method == http.MethodPost:
+		s.handleCancel(w, strings.TrimSuffix(id, "/cancel"))
+	case id != "" && r.Method == http.MethodGet:
+		s.handleGet(w, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Service) handleList(w http.ResponseWriter) {
+	jobs, err := s.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, jobs)
+}
+
+func (s *Service) handleGet(w http.ResponseWriter, id string) {
+	job, err := s.Get(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, job)
+}
+
+func (s *Service) handleCancel(w http.ResponseWriter, id string) {
+	if err := s.Cancel(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
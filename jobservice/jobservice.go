@@ -0,0 +1,247 @@
+// Package jobservice turns activate/deactivate calls into persisted jobs:
+// each call becomes a Job row with a stable ID and a lifecycle (Pending ->
+// Running -> Succeeded/Failed), so the outcome -- including the worker's
+// own error and how many attempts the Activator needed -- survives past the
+// log line and can be inspected after the fact. jobservice itself does not
+// retry; retries happen once, inside the Activator's xfer layer, and are
+// simply reported here.
+package jobservice
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/log"
+	"v9_deployment_manager/worker"
+)
+
+type Job = database.JobRecord
+
+const awaitPollInterval = 50 * time.Millisecond
+
+// Executor performs the work a job describes, reporting how many attempts
+// it took (the Activator's xfer layer retries internally; jobservice does
+// not retry on top of it). ActivatorExecutor is the production
+// implementation; tests can substitute their own.
+type Executor interface {
+	Execute(ctx context.Context, job Job) (result string, attempts int, err error)
+}
+
+// Store persists jobs. *database.Driver satisfies this; tests can
+// substitute an in-memory fake.
+type Store interface {
+	CreateJob(job Job) error
+	UpdateJob(job Job) error
+	GetJob(id string) (Job, error)
+	ListJobs() ([]Job, error)
+}
+
+// pendingJob is what runLoop pulls off the queue: a job ID plus the caller's
+// ctx, so a cancelled generation aborts the execution itself instead of just
+// the caller giving up on Await.
+type pendingJob struct {
+	id  string
+	ctx context.Context
+}
+
+// Service enqueues jobs, persists their lifecycle via a Store, and runs
+// them against an Executor from a small worker pool.
+type Service struct {
+	store    Store
+	executor Executor
+	logger   *log.Logger
+
+	pending chan pendingJob
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+// NewService starts a pool of poolSize workers pulling pending jobs and
+// executing them against executor. A nil logger falls back to log.Default.
+func NewService(store Store, executor Executor, poolSize int, logger *log.Logger) *Service {
+	if logger == nil {
+		logger = log.Default
+	}
+
+	s := &Service{
+		store:    store,
+		executor: executor,
+		logger:   logger,
+		pending:  make(chan pendingJob, 1024),
+		cancels:  make(map[string]context.CancelFunc),
+	}
+
+	for i := 0; i < poolSize; i++ {
+		go s.runLoop()
+	}
+
+	return s
+}
+
+// Enqueue persists a new job and schedules it for execution, returning
+// immediately with the job in its Pending state. ctx is carried through to
+// the job's execution: if ctx is cancelled (a new dirty-state generation
+// superseding this one, say), the in-flight attempt against the worker is
+// aborted rather than merely abandoned by the caller.
+func (s *Service) Enqueue(ctx context.Context, kind database.JobKind, compID worker.ComponentID, workerURL string) (Job, error) {
+	now := time.Now()
+	job := Job{
+		ID:          fmt.Sprintf("job-%s-%d", kind, now.UnixNano()),
+		Kind:        kind,
+		ComponentID: compID,
+		WorkerURL:   workerURL,
+		State:       database.JobPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if err := s.store.CreateJob(job); err != nil {
+		return Job{}, err
+	}
+
+	s.pending <- pendingJob{id: job.ID, ctx: ctx}
+	return job, nil
+}
+
+// Get returns a single job by ID, for the inspect endpoint.
+func (s *Service) Get(id string) (Job, error) {
+	return s.store.GetJob(id)
+}
+
+// List returns every known job, most recent first, for the list endpoint.
+func (s *Service) List() ([]Job, error) {
+	return s.store.ListJobs()
+}
+
+// Cancel marks a job Cancelled and, if it is currently executing, cancels
+// its context so the in-flight attempt is aborted.
+func (s *Service) Cancel(id string) error {
+	job, err := s.store.GetJob(id)
+	if err != nil {
+		return err
+	}
+	if isTerminal(job.State) {
+		return nil
+	}
+
+	job.State = database.JobCancelled
+	job.UpdatedAt = time.Now()
+	if err := s.store.UpdateJob(job); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	cancel, ok := s.cancels[id]
+	s.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return nil
+}
+
+// Await blocks until job id reaches a terminal state (or ctx is done),
+// returning its final record. HandleDirtyState uses this so a batch of
+// enqueued jobs can still be awaited the way a directly-awaited transfer
+// was before -- enqueuing doesn't have to mean "fire and forget".
+func (s *Service) Await(ctx context.Context, id string) (Job, error) {
+	for {
+		job, err := s.store.GetJob(id)
+		if err != nil {
+			return Job{}, err
+		}
+		if isTerminal(job.State) {
+			return job, nil
+		}
+
+		select {
+		case <-time.After(awaitPollInterval):
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		}
+	}
+}
+
+func isTerminal(state database.JobState) bool {
+	switch state {
+	case database.JobSucceeded, database.JobFailed, database.JobCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Service) runLoop() {
+	for req := range s.pending {
+		s.run(req.id, req.ctx)
+	}
+}
+
+func (s *Service) run(id string, parent context.Context) {
+	logger := s.logger.With(log.F("job_id", id))
+
+	job, err := s.store.GetJob(id)
+	if err != nil {
+		logger.Error("jobservice: failed to load job", log.F("error", err))
+		return
+	}
+	if job.State == database.JobCancelled {
+		return
+	}
+	logger = logger.With(
+		log.F("kind", job.Kind),
+		log.F("user", job.ComponentID.User),
+		log.F("repo", job.ComponentID.Repo),
+		log.F("worker_url", job.WorkerURL),
+	)
+
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	s.mu.Lock()
+	s.cancels[id] = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.cancels, id)
+		s.mu.Unlock()
+		cancel()
+	}()
+
+	job.State = database.JobRunning
+	job.UpdatedAt = time.Now()
+	if err := s.store.UpdateJob(job); err != nil {
+		logger.Error("jobservice: failed to persist job", log.F("error", err))
+	}
+
+	result, attempts, err := s.executor.Execute(ctx, job)
+	job.Attempts = attempts
+	job.UpdatedAt = time.Now()
+
+	if err != nil {
+		// A job aborted through Cancel keeps its Cancelled state.
+		if current, getErr := s.store.GetJob(id); getErr == nil && current.State == database.JobCancelled {
+			logger.Info("Job cancelled", log.F("attempts", attempts))
+			return
+		}
+		job.State = database.JobFailed
+		job.Error = err.Error()
+		if err := s.store.UpdateJob(job); err != nil {
+			logger.Error("jobservice: failed to persist job", log.F("error", err))
+		}
+		logger.Error("Job failed", log.F("attempts", attempts), log.F("error", err))
+		return
+	}
+
+	job.State = database.JobSucceeded
+	job.Result = result
+	job.Error = ""
+	if err := s.store.UpdateJob(job); err != nil {
+		logger.Error("jobservice: failed to persist job", log.F("error", err))
+	}
+	logger.Info("Job succeeded", log.F("attempts", attempts))
+}
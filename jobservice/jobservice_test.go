@@ -0,0 +1,184 @@
+package jobservice
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"v9_deployment_manager/database"
+	"v9_deployment_manager/worker"
+)
+
+type memStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+func newMemStore() *memStore {
+	return &memStore{jobs: make(map[string]Job)}
+}
+
+func (m *memStore) CreateJob(job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	return nil
+}
+
+func (m *memStore) UpdateJob(job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	return nil
+}
+
+func (m *memStore) GetJob(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, errors.New("job not found")
+	}
+	return job, nil
+}
+
+func (m *memStore) ListJobs() ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+type fakeExecutor struct {
+	fn func(ctx context.Context, job Job) (string, int, error)
+}
+
+func (f *fakeExecutor) Execute(ctx context.Context, job Job) (string, int, error) {
+	return f.fn(ctx, job)
+}
+
+func TestServiceEnqueueSucceeds(t *testing.T) {
+	store := newMemStore()
+	executor := &fakeExecutor{fn: func(ctx context.Context, job Job) (string, int, error) {
+		return "resolved-hash", 1, nil
+	}}
+	svc := NewService(store, executor, 2, nil)
+
+	job, err := svc.Enqueue(context.Background(), database.ActivateJob, worker.ComponentID{User: "u", Repo: "r", Hash: "HEAD"}, "worker-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	completed, err := svc.Await(context.Background(), job.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if completed.State != database.JobSucceeded {
+		t.Fatalf("expected job to succeed, got state %q (err %q)", completed.State, completed.Error)
+	}
+	if completed.Result != "resolved-hash" {
+		t.Fatalf("unexpected result: %q", completed.Result)
+	}
+}
+
+// TestServiceDoesNotRetryOnItsOwn confirms jobservice calls the Executor
+// exactly once per job and simply persists whatever it reports -- retrying
+// is the Activator's xfer layer's job (see executor.go), not jobservice's,
+// so a permanently-failing job shouldn't take two retry cycles to surface.
+func TestServiceDoesNotRetryOnItsOwn(t *testing.T) {
+	store := newMemStore()
+	var calls int32
+	executor := &fakeExecutor{fn: func(ctx context.Context, job Job) (string, int, error) {
+		calls++
+		// Simulate the Activator having already retried internally.
+		return "", 4, errors.New("worker unreachable")
+	}}
+	svc := NewService(store, executor, 1, nil)
+
+	job, err := svc.Enqueue(context.Background(), database.DeactivateJob, worker.ComponentID{User: "u", Repo: "r", Hash: "h"}, "worker-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	completed, err := svc.Await(context.Background(), job.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if completed.State != database.JobFailed {
+		t.Fatalf("expected job to fail, got state %q", completed.State)
+	}
+	if completed.Error != "worker unreachable" {
+		t.Fatalf("expected the worker's error to be persisted, got %q", completed.Error)
+	}
+	if calls != 1 {
+		t.Fatalf("expected jobservice to call the executor exactly once, got %d", calls)
+	}
+	if completed.Attempts != 4 {
+		t.Fatalf("expected the job to report the executor's own attempt count, got %d", completed.Attempts)
+	}
+}
+
+func TestServiceCancelAbortsInFlightJob(t *testing.T) {
+	store := newMemStore()
+	started := make(chan struct{})
+	executor := &fakeExecutor{fn: func(ctx context.Context, job Job) (string, int, error) {
+		close(started)
+		<-ctx.Done()
+		return "", 1, ctx.Err()
+	}}
+	svc := NewService(store, executor, 1, nil)
+
+	job, err := svc.Enqueue(context.Background(), database.ActivateJob, worker.ComponentID{User: "u", Repo: "r", Hash: "h"}, "worker-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	<-started
+	if err := svc.Cancel(job.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	completed, err := store.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if completed.State != database.JobCancelled {
+		t.Fatalf("expected job to be left cancelled, got state %q", completed.State)
+	}
+}
+
+func TestServiceAwaitRespectsContext(t *testing.T) {
+	store := newMemStore()
+	executor := &fakeExecutor{fn: func(ctx context.Context, job Job) (string, int, error) {
+		<-ctx.Done()
+		return "", 1, ctx.Err()
+	}}
+	svc := NewService(store, executor, 1, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	job, err := svc.Enqueue(ctx, database.ActivateJob, worker.ComponentID{User: "u", Repo: "r", Hash: "h"}, "worker-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := svc.Await(ctx, job.ID); err == nil {
+		t.Fatalf("expected Await to time out while the job is still running")
+	}
+
+	// The job's own execution context is the one passed to Enqueue, so it
+	// should actually abort (not just be abandoned by Await) once ctx expires.
+	completed, err := svc.Await(context.Background(), job.ID)
+	if err != nil {
+		t.Fatalf("unexpected error waiting for the job to finish aborting: %v", err)
+	}
+	if completed.State != database.JobFailed {
+		t.Fatalf("expected the job to be aborted once its context expired, got state %q", completed.State)
+	}
+}
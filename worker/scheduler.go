@@ -0,0 +1,171 @@
+package worker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoCapacity is returned when a Scheduler cannot find any healthy worker
+// that qualifies for placement.
+var ErrNoCapacity = errors.New("worker: no capacity available")
+
+// Scheduler decides which worker an activation should land on. Tests
+// substitute a deterministic implementation in place of LoadAwareScheduler.
+type Scheduler interface {
+	// Pick returns the least-loaded healthy worker among candidates. If
+	// excludeRunning is set, workers already running compPath are skipped,
+	// returning ErrNoCapacity if none of the candidates qualify.
+	Pick(candidates []*V9Worker, compPath ComponentPath, excludeRunning bool) (*V9Worker, error)
+
+	// ReportActivateResult feeds the outcome of an activate/deactivate call
+	// back into the scheduler's health tracking for w.
+	ReportActivateResult(w *V9Worker, err error)
+}
+
+const (
+	defaultStatusCacheTTL     = 5 * time.Second
+	defaultUnhealthyThreshold = 3
+)
+
+type cachedStatus struct {
+	status    StatusResponse
+	fetchedAt time.Time
+}
+
+type healthState struct {
+	consecutiveFailures int
+	unhealthy           bool
+}
+
+// LoadAwareScheduler scores candidate workers by their self-reported
+// CPU/memory/network usage and picks the least-loaded one, caching recent
+// Status() results for a short TTL and excluding workers that have failed
+// too many consecutive probes or activations.
+type LoadAwareScheduler struct {
+	cacheTTL           time.Duration
+	unhealthyThreshold int
+
+	mu     sync.Mutex
+	cache  map[string]cachedStatus
+	health map[string]*healthState
+}
+
+func NewLoadAwareScheduler() *LoadAwareScheduler {
+	return &LoadAwareScheduler{
+		cacheTTL:           defaultStatusCacheTTL,
+		unhealthyThreshold: defaultUnhealthyThreshold,
+		cache:              make(map[string]cachedStatus),
+		health:             make(map[string]*healthState),
+	}
+}
+
+func (s *LoadAwareScheduler) healthFor(url string) *healthState {
+	h, ok := s.health[url]
+	if !ok {
+		h = &healthState{}
+		s.health[url] = h
+	}
+	return h
+}
+
+func (s *LoadAwareScheduler) isUnhealthy(w *V9Worker) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.healthFor(w.URL).unhealthy
+}
+
+func (s *LoadAwareScheduler) recordFailure(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := s.healthFor(url)
+	h.consecutiveFailures++
+	if h.consecutiveFailures >= s.unhealthyThreshold {
+		h.unhealthy = true
+	}
+}
+
+func (s *LoadAwareScheduler) recordSuccess(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := s.healthFor(url)
+	h.consecutiveFailures = 0
+	h.unhealthy = false
+}
+
+// ReportActivateResult lets callers outside of Pick (namely the action
+// manager, after an activate/deactivate call) feed health signal back in.
+func (s *LoadAwareScheduler) ReportActivateResult(w *V9Worker, err error) {
+	if err != nil {
+		s.recordFailure(w.URL)
+		return
+	}
+	s.recordSuccess(w.URL)
+}
+
+// statusFor returns a recent Status() for w, either from cache or freshly
+// fetched. An unhealthy worker always gets a fresh probe so it can recover.
+func (s *LoadAwareScheduler) statusFor(w *V9Worker) (StatusResponse, error) {
+	s.mu.Lock()
+	unhealthy := s.healthFor(w.URL).unhealthy
+	cached, ok := s.cache[w.URL]
+	fresh := ok && !unhealthy && time.Since(cached.fetchedAt) < s.cacheTTL
+	s.mu.Unlock()
+
+	if fresh {
+		return cached.status, nil
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		s.recordFailure(w.URL)
+		return StatusResponse{}, err
+	}
+
+	s.mu.Lock()
+	s.cache[w.URL] = cachedStatus{status: status, fetchedAt: time.Now()}
+	s.mu.Unlock()
+	s.recordSuccess(w.URL)
+
+	return status, nil
+}
+
+// loadScore weights CPU, memory and network usage into a single comparable
+// figure -- lower is less loaded.
+func loadScore(status StatusResponse) float64 {
+	return 0.4*status.CPUUsage + 0.4*status.MemoryUsage + 0.2*status.NetworkUsage
+}
+
+func (s *LoadAwareScheduler) Pick(candidates []*V9Worker, compPath ComponentPath, excludeRunning bool) (*V9Worker, error) {
+	var best *V9Worker
+	var bestScore float64
+
+	for _, w := range candidates {
+		// Note: we don't skip unhealthy workers here. statusFor always
+		// re-probes an unhealthy worker (rather than serving its stale
+		// cached status), so this is also the only path back to healthy --
+		// skipping before the probe would make "unhealthy" permanent.
+		status, err := s.statusFor(w)
+		if err != nil {
+			continue
+		}
+
+		if excludeRunning && status.ContainsPath(compPath) {
+			continue
+		}
+
+		score := loadScore(status)
+		if best == nil || score < bestScore {
+			best = w
+			bestScore = score
+		}
+	}
+
+	if best == nil {
+		return nil, ErrNoCapacity
+	}
+
+	return best, nil
+}
@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func statusServer(t *testing.T, status StatusResponse) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body, _ := json.Marshal(status)
+		w.Write(body)
+	}))
+}
+
+func TestLoadAwareSchedulerPicksLeastLoaded(t *testing.T) {
+	busy := statusServer(t, StatusResponse{CPUUsage: 0.9, MemoryUsage: 0.8})
+	defer busy.Close()
+	idle := statusServer(t, StatusResponse{CPUUsage: 0.1, MemoryUsage: 0.1})
+	defer idle.Close()
+
+	workers := []*V9Worker{
+		{URL: busy.Listener.Addr().String()},
+		{URL: idle.Listener.Addr().String()},
+	}
+
+	s := NewLoadAwareScheduler()
+	picked, err := s.Pick(workers, ComponentPath{User: "u", Repo: "r"}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if picked.URL != workers[1].URL {
+		t.Fatalf("expected the idle worker to be picked, got %s", picked.URL)
+	}
+}
+
+func TestLoadAwareSchedulerExcludesRunning(t *testing.T) {
+	running := statusServer(t, StatusResponse{
+		ActiveComponents: []ComponentStats{{ID: ComponentID{User: "u", Repo: "r", Hash: "h"}}},
+	})
+	defer running.Close()
+
+	workers := []*V9Worker{{URL: running.Listener.Addr().String()}}
+
+	s := NewLoadAwareScheduler()
+	_, err := s.Pick(workers, ComponentPath{User: "u", Repo: "r"}, true)
+	if err != ErrNoCapacity {
+		t.Fatalf("expected ErrNoCapacity, got %v", err)
+	}
+}
+
+func TestLoadAwareSchedulerMarksUnhealthyAfterFailures(t *testing.T) {
+	s := NewLoadAwareScheduler()
+	w := &V9Worker{URL: "127.0.0.1:0"} // nothing listening -- Status() will fail
+
+	for i := 0; i < defaultUnhealthyThreshold; i++ {
+		if _, err := s.Pick([]*V9Worker{w}, ComponentPath{}, false); err != ErrNoCapacity {
+			t.Fatalf("expected ErrNoCapacity while probing, got %v", err)
+		}
+	}
+
+	if !s.isUnhealthy(w) {
+		t.Fatalf("expected worker to be marked unhealthy after %d consecutive failures", defaultUnhealthyThreshold)
+	}
+}
+
+func TestLoadAwareSchedulerRecoversAfterSuccessfulProbe(t *testing.T) {
+	var failures int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first defaultUnhealthyThreshold probes, then start
+		// succeeding -- simulates a worker that comes back up.
+		if atomic.LoadInt32(&failures) < int32(defaultUnhealthyThreshold) {
+			atomic.AddInt32(&failures, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		body, _ := json.Marshal(StatusResponse{CPUUsage: 0.1})
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	w := &V9Worker{URL: srv.Listener.Addr().String()}
+	s := NewLoadAwareScheduler()
+
+	for i := 0; i < defaultUnhealthyThreshold; i++ {
+		if _, err := s.Pick([]*V9Worker{w}, ComponentPath{}, false); err != ErrNoCapacity {
+			t.Fatalf("expected ErrNoCapacity while probing, got %v", err)
+		}
+	}
+	if !s.isUnhealthy(w) {
+		t.Fatalf("expected worker to be marked unhealthy after consecutive failures")
+	}
+
+	// Pick must still re-probe an unhealthy worker rather than excluding it
+	// outright -- this probe succeeds, so the worker should recover.
+	picked, err := s.Pick([]*V9Worker{w}, ComponentPath{}, false)
+	if err != nil {
+		t.Fatalf("expected the worker to recover after a successful probe, got %v", err)
+	}
+	if picked.URL != w.URL {
+		t.Fatalf("expected the recovered worker to be picked, got %v", picked)
+	}
+	if s.isUnhealthy(w) {
+		t.Fatalf("expected worker to be marked healthy again after a successful probe")
+	}
+}
@@ -2,7 +2,9 @@ package worker
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"v9_deployment_manager/log"
@@ -10,6 +12,18 @@ import (
 
 type V9Worker struct {
 	URL string
+
+	// Logger receives this worker's log lines, tagged with worker_url. A
+	// nil Logger falls back to log.Default.
+	Logger *log.Logger
+}
+
+func (worker *V9Worker) logger() *log.Logger {
+	base := worker.Logger
+	if base == nil {
+		base = log.Default
+	}
+	return base.With(log.F("worker_url", worker.URL))
 }
 
 type ComponentPath struct {
@@ -38,6 +52,11 @@ type deactivateRequest struct {
 	ID ComponentID `json:"id"`
 }
 
+type activateResponse struct {
+	ResolvedHash string `json:"resolved_hash"`
+	Error        string `json:"error"`
+}
+
 // Build activate post body
 func createDeactivateBody(compID ComponentID) ([]byte, error) {
 	body, err := json.Marshal(deactivateRequest{compID})
@@ -114,64 +133,103 @@ type LogResponse struct {
 	Logs []ComponentLog `json:"logs"`
 }
 
-func (worker *V9Worker) post(route string, body []byte) (*http.Response, error) {
+func (worker *V9Worker) post(ctx context.Context, route string, body []byte) (*http.Response, error) {
 	url := "http://" + worker.URL + route
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
 	if err != nil {
-		log.Error.Println("Failed to post", err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		worker.logger().Error("Failed to post", log.F("route", route), log.F("error", err))
 		return nil, err
 	}
 
 	return resp, nil
 }
 
-func (worker *V9Worker) get(route string) (*http.Response, error) {
+func (worker *V9Worker) get(ctx context.Context, route string) (*http.Response, error) {
 	url := "http://" + worker.URL + route
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Error.Println("Failed to get", err)
+		worker.logger().Error("Failed to get", log.F("route", route), log.F("error", err))
 		return nil, err
 	}
 
 	return resp, nil
 }
 
-func (worker *V9Worker) Activate(component ComponentID, tarPath string) error {
+// Activate asks the worker to run component at tarPath, returning the
+// resolved hash the worker ended up running.
+func (worker *V9Worker) Activate(ctx context.Context, component ComponentID, tarPath string) (string, error) {
+	logger := worker.logger().With(
+		log.F("user", component.User),
+		log.F("repo", component.Repo),
+		log.F("hash", component.Hash),
+	)
+
 	// Marshal information into json body
 	body, err := createActivateBody(component, tarPath, "docker-archive")
 	if err != nil {
-		log.Error.Println("Failed to create activation body", err)
-		return err
+		logger.Error("Failed to create activation body", log.F("error", err))
+		return "", err
 	}
 
 	// Make activate post request
-	resp, err := worker.post("/meta/activate", body)
+	resp, err := worker.post(ctx, "/meta/activate", body)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Error.Println("Failure to read response from worker", err)
-		return err
+		logger.Error("Failure to read response from worker", log.F("error", err))
+		return "", err
 	}
 
-	// TODO: Look for activate errors and store them somewhere
-	log.Info.Println("Response from worker:", string(respBody))
-	return nil
+	var activateResp activateResponse
+	if err := json.Unmarshal(respBody, &activateResp); err != nil {
+		logger.Error("Failed to parse activate response from worker", log.F("error", err))
+		return "", err
+	}
+	if activateResp.Error != "" {
+		logger.Error("Worker reported an activate error", log.F("error", activateResp.Error))
+		return "", errors.New(activateResp.Error)
+	}
+
+	resolvedHash := activateResp.ResolvedHash
+	if resolvedHash == "" {
+		resolvedHash = component.Hash
+	}
+
+	logger.Debug("Activated component", log.F("resolved_hash", resolvedHash))
+	return resolvedHash, nil
 }
 
-func (worker *V9Worker) Deactivate(component ComponentID) error {
+func (worker *V9Worker) Deactivate(ctx context.Context, component ComponentID) error {
+	logger := worker.logger().With(
+		log.F("user", component.User),
+		log.F("repo", component.Repo),
+		log.F("hash", component.Hash),
+	)
+
 	// Marshal information into json body
 	body, err := createDeactivateBody(component)
 	if err != nil {
-		log.Error.Println("Failed to create deactivation body", err)
+		logger.Error("Failed to create deactivation body", log.F("error", err))
 		return err
 	}
 
 	// Make deactivate post request
-	resp, err := worker.post("/meta/deactivate", body)
+	resp, err := worker.post(ctx, "/meta/deactivate", body)
 	if err != nil {
 		return err
 	}
@@ -179,38 +237,37 @@ func (worker *V9Worker) Deactivate(component ComponentID) error {
 
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Error.Println("Failure to read response from worker", err)
+		logger.Error("Failure to read response from worker", log.F("error", err))
 		return err
 	}
 
 	// TODO: Look for deactivate errors and store them somewhere
-	log.Info.Println("Response from worker:", string(respBody))
+	logger.Debug("Deactivated component", log.F("response", string(respBody)))
 	return nil
 }
 
 // Deactivate component
 func DeactivateComponentEverywhere(compID ComponentID, workers []*V9Worker) {
 	for i := range workers {
-		err := workers[i].Deactivate(compID)
+		err := workers[i].Deactivate(context.Background(), compID)
 		if err != nil {
-			log.Info.Println("Failed to deactivate worker:", i, err)
+			workers[i].logger().Warn("Failed to deactivate worker", log.F("error", err))
 			// This can fail and should fall through
 		}
 	}
 }
 
 func (worker *V9Worker) Logs() (LogResponse, error) {
-	url := "http://" + worker.URL + "/meta/logs"
-	resp, err := http.Get(url)
+	resp, err := worker.get(context.Background(), "/meta/logs")
 	if err != nil {
-		log.Error.Println("Failed to get logs", err)
+		worker.logger().Error("Failed to get logs", log.F("error", err))
 		return LogResponse{}, err
 	}
 	defer resp.Body.Close()
 
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Error.Println("Failure to read response from worker", err)
+		worker.logger().Error("Failure to read response from worker", log.F("error", err))
 		return LogResponse{}, err
 	}
 
@@ -224,16 +281,16 @@ func (worker *V9Worker) Logs() (LogResponse, error) {
 }
 
 func (worker *V9Worker) Status() (StatusResponse, error) {
-	resp, err := worker.get("/meta/status")
+	resp, err := worker.get(context.Background(), "/meta/status")
 	if err != nil {
-		log.Error.Println("Failed to get status", err)
+		worker.logger().Error("Failed to get status", log.F("error", err))
 		return StatusResponse{}, err
 	}
 	defer resp.Body.Close()
 
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Error.Println("Failure to read status response from worker", err)
+		worker.logger().Error("Failure to read status response from worker", log.F("error", err))
 		return StatusResponse{}, err
 	}
 
@@ -243,5 +300,12 @@ func (worker *V9Worker) Status() (StatusResponse, error) {
 		return StatusResponse{}, err
 	}
 
+	worker.logger().Debug("Fetched status",
+		log.F("cpu_usage", statusResponse.CPUUsage),
+		log.F("memory_usage", statusResponse.MemoryUsage),
+		log.F("network_usage", statusResponse.NetworkUsage),
+		log.F("active_components", len(statusResponse.ActiveComponents)),
+	)
+
 	return statusResponse, nil
 }
@@ -0,0 +1,220 @@
+// Package xfer provides a transfer manager modeled on Docker's image
+// upload/download manager: it deduplicates in-flight work by key, caps
+// concurrency per worker, and retries failures with exponential backoff.
+package xfer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Kind distinguishes the two flavors of transfer so they can be rate
+// limited independently.
+type Kind int
+
+const (
+	KindActivate Kind = iota
+	KindDeactivate
+)
+
+// Key identifies a de-duplicable unit of work: one component on one worker.
+type Key struct {
+	ComponentID string
+	WorkerURL   string
+}
+
+// DoFunc performs the actual work for a transfer. It must honor ctx
+// cancellation so that stale or superseded transfers can be dropped.
+type DoFunc func(ctx context.Context) (interface{}, error)
+
+// Config controls per-worker concurrency and retry behavior.
+type Config struct {
+	MaxConcurrentActivates   int
+	MaxConcurrentDeactivates int
+
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	MaxAttempts    int
+}
+
+// DefaultConfig returns the concurrency/backoff settings the deployment
+// manager uses in production.
+func DefaultConfig() Config {
+	return Config{
+		MaxConcurrentActivates:   3,
+		MaxConcurrentDeactivates: 3,
+		InitialBackoff:           time.Second,
+		MaxBackoff:               30 * time.Second,
+		MaxAttempts:              6,
+	}
+}
+
+// Transfer is an in-flight or completed unit of work. Multiple callers can
+// attach to the same Transfer (by submitting the same Key) and all observe
+// the same result.
+type Transfer struct {
+	key    Key
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	result   interface{}
+	err      error
+	attempts int
+}
+
+// Done returns a channel that is closed once the transfer (including all of
+// its retries) has finished.
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns the terminal error of the transfer. It is only valid to call
+// after Done() has been closed.
+func (t *Transfer) Err() error {
+	return t.err
+}
+
+// Result returns the value returned by the DoFunc on success. It is only
+// valid to call after Done() has been closed.
+func (t *Transfer) Result() interface{} {
+	return t.result
+}
+
+// Attempts returns how many times fn was actually invoked, including
+// retries. It is only valid to call after Done() has been closed; a
+// transfer cancelled before it ever acquired its concurrency slot reports 0.
+func (t *Transfer) Attempts() int {
+	return t.attempts
+}
+
+// TransferManager deduplicates and rate-limits activate/deactivate calls
+// across workers.
+type TransferManager struct {
+	cfg Config
+
+	mu        sync.Mutex
+	transfers map[Key]*Transfer
+
+	activateSems   map[string]chan struct{}
+	deactivateSems map[string]chan struct{}
+}
+
+func NewTransferManager(cfg Config) *TransferManager {
+	return &TransferManager{
+		cfg:            cfg,
+		transfers:      make(map[Key]*Transfer),
+		activateSems:   make(map[string]chan struct{}),
+		deactivateSems: make(map[string]chan struct{}),
+	}
+}
+
+func (tm *TransferManager) semFor(kind Kind, workerURL string) chan struct{} {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	sems := tm.activateSems
+	limit := tm.cfg.MaxConcurrentActivates
+	if kind == KindDeactivate {
+		sems = tm.deactivateSems
+		limit = tm.cfg.MaxConcurrentDeactivates
+	}
+
+	sem, ok := sems[workerURL]
+	if !ok {
+		sem = make(chan struct{}, limit)
+		sems[workerURL] = sem
+	}
+	return sem
+}
+
+// Submit starts a transfer for key, or attaches to an already in-flight one
+// for the same key. The returned Transfer completes once fn has succeeded,
+// exhausted its retries, or ctx is cancelled.
+func (tm *TransferManager) Submit(ctx context.Context, key Key, kind Kind, fn DoFunc) *Transfer {
+	tm.mu.Lock()
+	if existing, ok := tm.transfers[key]; ok {
+		tm.mu.Unlock()
+		return existing
+	}
+
+	transferCtx, cancel := context.WithCancel(ctx)
+	t := &Transfer{
+		key:    key,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	tm.transfers[key] = t
+	tm.mu.Unlock()
+
+	go tm.run(transferCtx, t, kind, fn)
+
+	return t
+}
+
+// Cancel cancels the in-flight transfer for key, if there is one: a queued
+// or backing-off transfer is dropped without calling fn again, and an
+// attempt that is already running has its ctx cancelled. Transfers that have
+// already finished are not affected.
+func (tm *TransferManager) Cancel(key Key) {
+	tm.mu.Lock()
+	t, ok := tm.transfers[key]
+	tm.mu.Unlock()
+	if ok {
+		t.cancel()
+	}
+}
+
+func (tm *TransferManager) run(ctx context.Context, t *Transfer, kind Kind, fn DoFunc) {
+	defer tm.complete(t)
+
+	sem := tm.semFor(kind, t.key.WorkerURL)
+	select {
+	case sem <- struct{}{}:
+		defer func() { <-sem }()
+	case <-ctx.Done():
+		t.err = ctx.Err()
+		return
+	}
+
+	backoff := tm.cfg.InitialBackoff
+	for attempt := 1; attempt <= tm.cfg.MaxAttempts; attempt++ {
+		t.attempts = attempt
+		result, err := fn(ctx)
+		if err == nil {
+			t.result = result
+			t.err = nil
+			return
+		}
+
+		t.err = err
+		if ctx.Err() != nil {
+			t.err = ctx.Err()
+			return
+		}
+		if attempt == tm.cfg.MaxAttempts {
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			t.err = ctx.Err()
+			return
+		}
+
+		backoff *= 2
+		if backoff > tm.cfg.MaxBackoff {
+			backoff = tm.cfg.MaxBackoff
+		}
+	}
+}
+
+func (tm *TransferManager) complete(t *Transfer) {
+	tm.mu.Lock()
+	if tm.transfers[t.key] == t {
+		delete(tm.transfers, t.key)
+	}
+	tm.mu.Unlock()
+	close(t.done)
+}
@@ -0,0 +1,217 @@
+package xfer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.InitialBackoff = time.Millisecond
+	cfg.MaxBackoff = 5 * time.Millisecond
+	cfg.MaxAttempts = 3
+	return cfg
+}
+
+func TestSubmitDedupesInFlightKey(t *testing.T) {
+	tm := NewTransferManager(testConfig())
+	key := Key{ComponentID: "a/b/c", WorkerURL: "worker-1"}
+
+	var calls int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-release
+		return "ok", nil
+	}
+
+	first := tm.Submit(context.Background(), key, KindActivate, fn)
+	<-started
+
+	// A second submission for the same key while the first is in flight
+	// should attach to it rather than invoking fn again.
+	second := tm.Submit(context.Background(), key, KindActivate, fn)
+	if first != second {
+		t.Fatalf("expected second submission to attach to the existing transfer")
+	}
+
+	close(release)
+	<-first.Done()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected fn to be called exactly once, got %d", got)
+	}
+	if first.Err() != nil {
+		t.Fatalf("unexpected error: %v", first.Err())
+	}
+	if first.Result() != "ok" {
+		t.Fatalf("unexpected result: %v", first.Result())
+	}
+}
+
+func TestSubmitRetriesWithBackoff(t *testing.T) {
+	tm := NewTransferManager(testConfig())
+	key := Key{ComponentID: "a/b/c", WorkerURL: "worker-1"}
+
+	var attempts int32
+	fn := func(ctx context.Context) (interface{}, error) {
+		n := atomic.AddInt32(&attempts, 1)
+		if n < 3 {
+			return nil, errors.New("transient failure")
+		}
+		return "ok", nil
+	}
+
+	transfer := tm.Submit(context.Background(), key, KindActivate, fn)
+	<-transfer.Done()
+
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("expected 3 attempts, got %d", got)
+	}
+	if transfer.Err() != nil {
+		t.Fatalf("unexpected error: %v", transfer.Err())
+	}
+}
+
+func TestSubmitGivesUpAfterMaxAttempts(t *testing.T) {
+	tm := NewTransferManager(testConfig())
+	key := Key{ComponentID: "a/b/c", WorkerURL: "worker-1"}
+
+	var attempts int32
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nil, errors.New("permanent failure")
+	}
+
+	transfer := tm.Submit(context.Background(), key, KindActivate, fn)
+	<-transfer.Done()
+
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("expected exactly MaxAttempts (3) attempts, got %d", got)
+	}
+	if transfer.Err() == nil {
+		t.Fatalf("expected an error after exhausting retries")
+	}
+}
+
+func TestConcurrencyCappedPerWorker(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxConcurrentActivates = 2
+	tm := NewTransferManager(cfg)
+
+	var inFlight, maxInFlight int32
+	release := make(chan struct{})
+
+	fn := func(ctx context.Context) (interface{}, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			cur := atomic.LoadInt32(&maxInFlight)
+			if n <= cur || atomic.CompareAndSwapInt32(&maxInFlight, cur, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&inFlight, -1)
+		return nil, nil
+	}
+
+	transfers := make([]*Transfer, 0, 5)
+	for i := 0; i < 5; i++ {
+		key := Key{ComponentID: "comp", WorkerURL: "worker-1"}
+		// Use distinct component IDs so these don't dedupe against each other.
+		key.ComponentID = key.ComponentID + string(rune('a'+i))
+		transfers = append(transfers, tm.Submit(context.Background(), key, KindActivate, fn))
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for _, tr := range transfers {
+		<-tr.Done()
+	}
+
+	if got := atomic.LoadInt32(&maxInFlight); got > 2 {
+		t.Fatalf("expected at most 2 concurrent activates per worker, saw %d", got)
+	}
+}
+
+func TestCancelDropsQueuedTransfer(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxConcurrentActivates = 1
+	tm := NewTransferManager(cfg)
+	key := Key{ComponentID: "a/b/c", WorkerURL: "worker-1"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var called int32
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&called, 1)
+		return "ok", nil
+	}
+
+	// Fill the single activate slot so the next submission has to queue.
+	// Wait for the blocker to actually hold the slot (be inside fn) before
+	// submitting the one we're about to cancel -- otherwise it can win the
+	// race for the free slot and run before cancel() takes effect.
+	blocker := make(chan struct{})
+	started := make(chan struct{})
+	tm.Submit(context.Background(), Key{ComponentID: "blocker", WorkerURL: "worker-1"}, KindActivate, func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-blocker
+		return nil, nil
+	})
+	<-started
+
+	queued := tm.Submit(ctx, key, KindActivate, fn)
+	cancel()
+	<-queued.Done()
+	close(blocker)
+
+	if queued.Err() == nil {
+		t.Fatalf("expected cancellation error for queued transfer")
+	}
+	if atomic.LoadInt32(&called) != 0 {
+		t.Fatalf("fn should not have run for a cancelled, not-yet-started transfer")
+	}
+}
+
+// TestTransferManagerCancelByKey exercises TransferManager.Cancel directly --
+// callers that only hold a Key (not the ctx.CancelFunc a Submit call used)
+// still need a way to drop a stale queued transfer.
+func TestTransferManagerCancelByKey(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxConcurrentActivates = 1
+	tm := NewTransferManager(cfg)
+	key := Key{ComponentID: "a/b/c", WorkerURL: "worker-1"}
+
+	var called int32
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&called, 1)
+		return "ok", nil
+	}
+
+	blocker := make(chan struct{})
+	started := make(chan struct{})
+	tm.Submit(context.Background(), Key{ComponentID: "blocker", WorkerURL: "worker-1"}, KindActivate, func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-blocker
+		return nil, nil
+	})
+	<-started
+
+	queued := tm.Submit(context.Background(), key, KindActivate, fn)
+	tm.Cancel(key)
+	<-queued.Done()
+	close(blocker)
+
+	if queued.Err() == nil {
+		t.Fatalf("expected cancellation error for queued transfer")
+	}
+	if atomic.LoadInt32(&called) != 0 {
+		t.Fatalf("fn should not have run for a cancelled, not-yet-started transfer")
+	}
+}